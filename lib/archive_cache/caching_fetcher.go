@@ -0,0 +1,244 @@
+package archivecache
+
+import (
+	"fmt"
+	"io"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	githubfetch "github.com/vaguiner7/furan/lib/github_fetch"
+
+	"gopkg.in/DataDog/dd-trace-go.v1/ddtrace/tracer"
+)
+
+// cachePopulationIdleTimeout bounds how long the background goroutine
+// populating the cache will wait between reads from the caller's side of
+// the tee before giving up. Without this, a caller that stops draining
+// its reader mid-stream (e.g. an aborted build, the normal failure mode
+// this cache sees in production) would leave the populating goroutine
+// blocked on the pipe forever, wedging the key in c.inflight and hanging
+// every subsequent Get for it.
+const cachePopulationIdleTimeout = 30 * time.Second
+
+// Metrics tracks CachingFetcher cache effectiveness.
+type Metrics struct {
+	Hits                 uint64
+	Misses               uint64
+	BytesServedFromCache uint64
+}
+
+// Snapshot returns a copy of the current metric values.
+func (m *Metrics) Snapshot() Metrics {
+	return Metrics{
+		Hits:                 atomic.LoadUint64(&m.Hits),
+		Misses:               atomic.LoadUint64(&m.Misses),
+		BytesServedFromCache: atomic.LoadUint64(&m.BytesServedFromCache),
+	}
+}
+
+// CachingFetcher wraps a githubfetch.CodeFetcher with a content-addressed
+// archive cache keyed by "owner/repo@sha" plus the requested archive
+// format. Concurrent requests for the
+// same SHA are deduplicated via an in-flight map so only one of them
+// actually downloads; the rest wait for that download to finish
+// populating the cache and then open their own reader onto it.
+type CachingFetcher struct {
+	cf      githubfetch.CodeFetcher
+	backend CacheBackend
+	metrics Metrics
+
+	// idleTimeout is cachePopulationIdleTimeout in production; tests
+	// shrink it to exercise the abandoned-caller path without waiting
+	// 30 seconds.
+	idleTimeout time.Duration
+
+	mu       sync.Mutex
+	inflight map[string]*inflightGet
+}
+
+// inflightGet tracks a single in-progress cache population for a key;
+// done is closed once the populating Get has either finished writing the
+// cache entry or failed, with err set in the latter case.
+type inflightGet struct {
+	done chan struct{}
+	err  error
+}
+
+// NewCachingFetcher returns a CachingFetcher that serves cf's archives
+// out of backend whenever possible.
+func NewCachingFetcher(cf githubfetch.CodeFetcher, backend CacheBackend) *CachingFetcher {
+	return &CachingFetcher{
+		cf:          cf,
+		backend:     backend,
+		idleTimeout: cachePopulationIdleTimeout,
+		inflight:    map[string]*inflightGet{},
+	}
+}
+
+// Metrics returns the fetcher's running cache metrics.
+func (c *CachingFetcher) Metrics() Metrics {
+	return c.metrics.Snapshot()
+}
+
+// GetCommitSHA delegates directly to the wrapped CodeFetcher; resolving a
+// ref isn't itself cached, only the archive fetched for a resolved SHA.
+func (c *CachingFetcher) GetCommitSHA(parentSpan tracer.Span, owner string, repo string, ref string) (string, error) {
+	return c.cf.GetCommitSHA(parentSpan, owner, repo, ref)
+}
+
+func cacheKey(owner, repo, sha string, format githubfetch.ArchiveFormat) string {
+	return fmt.Sprintf("%s/%s@%s:%d", owner, repo, sha, format)
+}
+
+// Get resolves ref to a commit SHA, then serves the archive for
+// owner/repo@sha from cache when available. On a miss, it fetches from
+// the wrapped CodeFetcher and tees the stream into the cache as it's
+// read, so the caller that triggered the miss still sees the archive
+// stream live instead of waiting for the whole thing to land on disk
+// first. Concurrent misses for the same key are deduplicated: the first
+// caller becomes the one doing the tee, and every other caller for that
+// key waits for it to finish populating the cache and then opens its own
+// reader onto the now-cached entry.
+func (c *CachingFetcher) Get(parentSpan tracer.Span, owner string, repo string, ref string, opts githubfetch.GetOptions) (io.Reader, error) {
+	sha, err := c.cf.GetCommitSHA(parentSpan, owner, repo, ref)
+	if err != nil {
+		return nil, fmt.Errorf("error resolving ref to commit sha: %v", err)
+	}
+	key := cacheKey(owner, repo, sha, opts.Format)
+
+	if rc, ok, err := c.backend.Get(key); err != nil {
+		return nil, fmt.Errorf("error reading from cache: %v", err)
+	} else if ok {
+		atomic.AddUint64(&c.metrics.Hits, 1)
+		return &countingReadCloser{rc: rc, counter: &c.metrics.BytesServedFromCache}, nil
+	}
+
+	c.mu.Lock()
+	if inf, ok := c.inflight[key]; ok {
+		c.mu.Unlock()
+		<-inf.done
+		if inf.err != nil {
+			return nil, inf.err
+		}
+		atomic.AddUint64(&c.metrics.Hits, 1)
+		return c.openFromCache(key)
+	}
+	inf := &inflightGet{done: make(chan struct{})}
+	c.inflight[key] = inf
+	c.mu.Unlock()
+
+	atomic.AddUint64(&c.metrics.Misses, 1)
+	r, err := c.cf.Get(parentSpan, owner, repo, ref, opts)
+	if err != nil {
+		c.finishInflight(key, inf, err)
+		return nil, err
+	}
+
+	pr, pw := io.Pipe()
+	go func() {
+		putErr := c.backend.Put(key, pr)
+		pr.CloseWithError(putErr)
+		c.finishInflight(key, inf, putErr)
+	}()
+	return newTeeReader(r, pw, c.idleTimeout), nil
+}
+
+// finishInflight records err (nil on success) against inf, wakes up
+// anyone waiting on inf.done, and removes key from the in-flight map so
+// a later Get for the same key starts a fresh population.
+func (c *CachingFetcher) finishInflight(key string, inf *inflightGet, err error) {
+	inf.err = err
+	close(inf.done)
+	c.mu.Lock()
+	delete(c.inflight, key)
+	c.mu.Unlock()
+}
+
+// openFromCache opens a fresh reader onto key's now-populated cache entry.
+func (c *CachingFetcher) openFromCache(key string) (io.Reader, error) {
+	rc, ok, err := c.backend.Get(key)
+	if err != nil {
+		return nil, fmt.Errorf("error reading from cache: %v", err)
+	}
+	if !ok {
+		return nil, fmt.Errorf("cache entry missing for %v after populating it", key)
+	}
+	return &countingReadCloser{rc: rc, counter: &c.metrics.BytesServedFromCache}, nil
+}
+
+// teeReader copies everything read from r into pw as it's read, so a
+// concurrent reader on the other end of the pipe (here, the goroutine
+// writing the cache entry) observes the same bytes the caller does
+// without having to wait for the caller to finish first. A write failure
+// on pw (e.g. the cache side already bailed) is swallowed rather than
+// failed back to the caller, since a broken cache write shouldn't break
+// the archive the caller actually asked for; an error from r itself,
+// including io.EOF, is propagated to pw by closing it so the cache side
+// observes the same outcome. A watchdog timer closes pw with an error if
+// the caller goes idleTimeout without calling Read at all, so an
+// abandoned stream can't wedge the cache-population goroutine forever.
+// The timer only runs between Read calls, not during one: a slow but
+// still-progressing underlying fetch (e.g. a stalled-but-alive network
+// read) must not be mistaken for a caller that gave up.
+type teeReader struct {
+	r           io.Reader
+	pw          *io.PipeWriter
+	idleTimeout time.Duration
+	timer       *time.Timer
+}
+
+func newTeeReader(r io.Reader, pw *io.PipeWriter, idleTimeout time.Duration) *teeReader {
+	t := &teeReader{r: r, pw: pw, idleTimeout: idleTimeout}
+	t.timer = time.AfterFunc(idleTimeout, t.abandon)
+	return t
+}
+
+// abandon fires when the caller hasn't called Read for idleTimeout; it
+// fails the pipe so the goroutine blocked writing the cache entry gives
+// up instead of waiting forever.
+func (t *teeReader) abandon() {
+	t.pw.CloseWithError(fmt.Errorf("cache population abandoned: no read for %s", t.idleTimeout))
+}
+
+func (t *teeReader) Read(p []byte) (int, error) {
+	// Disarm the watchdog for the duration of the underlying read: the
+	// caller is actively blocked waiting on it, however slow it is, so
+	// it isn't idle. It's re-armed below once we're back to waiting on
+	// the caller's next Read call.
+	t.timer.Stop()
+	n, err := t.r.Read(p)
+	if n > 0 {
+		t.pw.Write(p[:n])
+	}
+	if err != nil {
+		if err == io.EOF {
+			t.pw.Close()
+		} else {
+			t.pw.CloseWithError(err)
+		}
+		return n, err
+	}
+	t.timer.Reset(t.idleTimeout)
+	return n, err
+}
+
+// countingReadCloser tallies bytes read from rc into BytesServedFromCache.
+type countingReadCloser struct {
+	rc      io.ReadCloser
+	counter *uint64
+}
+
+func (c *countingReadCloser) Read(p []byte) (int, error) {
+	n, err := c.rc.Read(p)
+	if n > 0 {
+		atomic.AddUint64(c.counter, uint64(n))
+	}
+	return n, err
+}
+
+func (c *countingReadCloser) Close() error {
+	return c.rc.Close()
+}
+
+var _ githubfetch.CodeFetcher = (*CachingFetcher)(nil)
@@ -0,0 +1,204 @@
+package archivecache
+
+import (
+	"io"
+	"io/ioutil"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	githubfetch "github.com/vaguiner7/furan/lib/github_fetch"
+
+	"gopkg.in/DataDog/dd-trace-go.v1/ddtrace/tracer"
+)
+
+// fakeFetcher is a minimal githubfetch.CodeFetcher that always resolves
+// ref to sha and counts how many times Get has actually been called, so
+// tests can assert on dedup behavior without a real network fetch.
+type fakeFetcher struct {
+	sha     string
+	content string
+
+	mu    sync.Mutex
+	calls int
+
+	// entered, if non-nil, is closed the first time Get is called, so a
+	// test can wait until a fetch is actually underway.
+	entered     chan struct{}
+	enteredOnce sync.Once
+	// blockUntil, if non-nil, is closed by the test once it wants Get to
+	// proceed, letting the test line up concurrent callers first.
+	blockUntil chan struct{}
+}
+
+func (f *fakeFetcher) GetCommitSHA(_ tracer.Span, _ string, _ string, _ string) (string, error) {
+	return f.sha, nil
+}
+
+func (f *fakeFetcher) Get(_ tracer.Span, _ string, _ string, _ string, _ githubfetch.GetOptions) (io.Reader, error) {
+	f.mu.Lock()
+	f.calls++
+	f.mu.Unlock()
+	if f.entered != nil {
+		f.enteredOnce.Do(func() { close(f.entered) })
+	}
+	if f.blockUntil != nil {
+		<-f.blockUntil
+	}
+	return strings.NewReader(f.content), nil
+}
+
+func newTestCache(t *testing.T) *DiskCache {
+	return newTestDiskCache(t, 0, time.Hour)
+}
+
+func TestCachingFetcherMissThenHit(t *testing.T) {
+	ff := &fakeFetcher{sha: "deadbeef", content: "archive bytes"}
+	cf := NewCachingFetcher(ff, newTestCache(t))
+
+	r, err := cf.Get(nil, "owner", "repo", "main", githubfetch.GetOptions{})
+	if err != nil {
+		t.Fatalf("Get (miss): %v", err)
+	}
+	got, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatalf("ReadAll: %v", err)
+	}
+	if string(got) != ff.content {
+		t.Errorf("Get (miss) content = %q, want %q", got, ff.content)
+	}
+
+	r, err = cf.Get(nil, "owner", "repo", "main", githubfetch.GetOptions{})
+	if err != nil {
+		t.Fatalf("Get (hit): %v", err)
+	}
+	got, err = ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatalf("ReadAll: %v", err)
+	}
+	if string(got) != ff.content {
+		t.Errorf("Get (hit) content = %q, want %q", got, ff.content)
+	}
+
+	if ff.calls != 1 {
+		t.Errorf("underlying fetcher called %v times, want 1", ff.calls)
+	}
+
+	m := cf.Metrics()
+	if m.Misses != 1 {
+		t.Errorf("Misses = %v, want 1", m.Misses)
+	}
+	if m.Hits != 1 {
+		t.Errorf("Hits = %v, want 1", m.Hits)
+	}
+	if m.BytesServedFromCache != uint64(len(ff.content)) {
+		t.Errorf("BytesServedFromCache = %v, want %v", m.BytesServedFromCache, len(ff.content))
+	}
+}
+
+func TestCachingFetcherDeduplicatesConcurrentMisses(t *testing.T) {
+	entered := make(chan struct{})
+	block := make(chan struct{})
+	ff := &fakeFetcher{sha: "deadbeef", content: "archive bytes", entered: entered, blockUntil: block}
+	cf := NewCachingFetcher(ff, newTestCache(t))
+
+	const n = 5
+	var wg sync.WaitGroup
+	results := make([]string, n)
+	errs := make([]error, n)
+
+	get := func(i int) {
+		defer wg.Done()
+		r, err := cf.Get(nil, "owner", "repo", "main", githubfetch.GetOptions{})
+		if err != nil {
+			errs[i] = err
+			return
+		}
+		b, err := ioutil.ReadAll(r)
+		if err != nil {
+			errs[i] = err
+			return
+		}
+		results[i] = string(b)
+	}
+
+	// Launch the leader and wait until it's actually inside the fetch
+	// (and so has already registered the in-flight entry) before
+	// launching the followers, so they're guaranteed to join it rather
+	// than racing to become their own leader.
+	wg.Add(1)
+	go get(0)
+	select {
+	case <-entered:
+	case <-time.After(time.Second):
+		t.Fatal("timed out waiting for the leader to start fetching")
+	}
+
+	for i := 1; i < n; i++ {
+		wg.Add(1)
+		go get(i)
+	}
+	close(block)
+	wg.Wait()
+
+	for i, err := range errs {
+		if err != nil {
+			t.Fatalf("goroutine %v: %v", i, err)
+		}
+	}
+	for i, got := range results {
+		if got != ff.content {
+			t.Errorf("goroutine %v content = %q, want %q", i, got, ff.content)
+		}
+	}
+
+	if ff.calls != 1 {
+		t.Errorf("underlying fetcher called %v times, want 1", ff.calls)
+	}
+}
+
+// TestCachingFetcherAbandonedReadDoesNotWedgeKey reproduces a caller that
+// aborts mid-stream without draining the reader to EOF (e.g. a build
+// that fails partway through) — the normal failure path this cache sees
+// in production. A second Get for the same key must still complete
+// instead of hanging on the abandoned population.
+func TestCachingFetcherAbandonedReadDoesNotWedgeKey(t *testing.T) {
+	ff := &fakeFetcher{sha: "deadbeef", content: "archive bytes"}
+	cf := NewCachingFetcher(ff, newTestCache(t))
+	cf.idleTimeout = 20 * time.Millisecond
+
+	r, err := cf.Get(nil, "owner", "repo", "main", githubfetch.GetOptions{})
+	if err != nil {
+		t.Fatalf("Get (first): %v", err)
+	}
+	// Read a few bytes and then abandon the stream entirely, like a
+	// build that dies partway through consuming it.
+	buf := make([]byte, 4)
+	if _, err := r.Read(buf); err != nil {
+		t.Fatalf("partial read: %v", err)
+	}
+	// Give the watchdog time to fire and finishInflight time to clean up
+	// the abandoned entry before trying again.
+	time.Sleep(10 * cf.idleTimeout)
+
+	done := make(chan error, 1)
+	go func() {
+		r2, err := cf.Get(nil, "owner", "repo", "main", githubfetch.GetOptions{})
+		if err != nil {
+			done <- err
+			return
+		}
+		_, err = ioutil.ReadAll(r2)
+		done <- err
+	}()
+
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("second Get: %v", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("second Get for the same key hung after the first caller abandoned its read")
+	}
+}
@@ -0,0 +1,184 @@
+// Package archivecache provides a content-addressed cache for archives
+// produced by a githubfetch.CodeFetcher, keyed by "owner/repo@sha" plus
+// the archive format, so
+// repeated builds of the same commit don't re-download the whole repo.
+package archivecache
+
+import (
+	"container/list"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// CacheBackend is a pluggable store for cached archive bytes. Get returns
+// ok=false (not an error) on a clean cache miss.
+type CacheBackend interface {
+	Get(key string) (r io.ReadCloser, ok bool, err error)
+	Put(key string, r io.Reader) error
+}
+
+// DiskCache is a CacheBackend backed by the local filesystem, with LRU
+// size eviction and a per-entry TTL.
+type DiskCache struct {
+	dir      string
+	maxBytes int64
+	ttl      time.Duration
+
+	mu    sync.Mutex
+	lru   *list.List // of *diskCacheEntry, front = most recently used
+	index map[string]*list.Element
+	size  int64
+}
+
+type diskCacheEntry struct {
+	key       string
+	path      string
+	size      int64
+	expiresAt time.Time
+}
+
+// NewDiskCache returns a DiskCache rooted at dir, evicting the
+// least-recently-used entries once the cache exceeds maxBytes, and
+// treating any entry older than ttl as a miss. dir is created if it
+// doesn't already exist.
+func NewDiskCache(dir string, maxBytes int64, ttl time.Duration) (*DiskCache, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("error creating cache dir: %v", err)
+	}
+	return &DiskCache{
+		dir:      dir,
+		maxBytes: maxBytes,
+		ttl:      ttl,
+		lru:      list.New(),
+		index:    map[string]*list.Element{},
+	}, nil
+}
+
+func (d *DiskCache) pathFor(key string) string {
+	return filepath.Join(d.dir, keyToFilename(key))
+}
+
+// keyToFilename escapes key's path separators so it can be used as a
+// single flat filename within the cache directory.
+func keyToFilename(key string) string {
+	out := make([]byte, 0, len(key))
+	for i := 0; i < len(key); i++ {
+		c := key[i]
+		if c == '/' || c == '@' || c == ':' {
+			out = append(out, '_')
+			continue
+		}
+		out = append(out, c)
+	}
+	return string(out)
+}
+
+// Get returns a reader over the cached bytes for key, if present and not
+// expired.
+func (d *DiskCache) Get(key string) (io.ReadCloser, bool, error) {
+	d.mu.Lock()
+	el, ok := d.index[key]
+	if !ok {
+		d.mu.Unlock()
+		return nil, false, nil
+	}
+	entry := el.Value.(*diskCacheEntry)
+	if time.Now().After(entry.expiresAt) {
+		d.removeEntryLocked(el)
+		d.mu.Unlock()
+		return nil, false, nil
+	}
+	d.lru.MoveToFront(el)
+	d.mu.Unlock()
+
+	f, err := os.Open(entry.path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, false, nil
+		}
+		return nil, false, fmt.Errorf("error opening cache entry: %v", err)
+	}
+	return f, true, nil
+}
+
+// Put stores the bytes read from r under key, evicting older entries as
+// needed to stay within maxBytes.
+func (d *DiskCache) Put(key string, r io.Reader) error {
+	tmp, err := ioutil.TempFile(d.dir, "tmp-")
+	if err != nil {
+		return fmt.Errorf("error creating temp cache file: %v", err)
+	}
+	defer os.Remove(tmp.Name()) // no-op once renamed
+
+	size, err := io.Copy(tmp, r)
+	if err != nil {
+		tmp.Close()
+		return fmt.Errorf("error writing cache entry: %v", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("error closing cache entry: %v", err)
+	}
+
+	dest := d.pathFor(key)
+	if err := os.Rename(tmp.Name(), dest); err != nil {
+		return fmt.Errorf("error finalizing cache entry: %v", err)
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if el, ok := d.index[key]; ok {
+		// The rename above already replaced this key's backing file in
+		// place (pathFor is a pure function of key), so only the stale
+		// accounting needs discarding here; removeEntryLocked would
+		// delete the file we just wrote.
+		d.forgetEntryLocked(el)
+	}
+	entry := &diskCacheEntry{
+		key:       key,
+		path:      dest,
+		size:      size,
+		expiresAt: time.Now().Add(d.ttl),
+	}
+	d.index[key] = d.lru.PushFront(entry)
+	d.size += size
+	d.evictLocked()
+	return nil
+}
+
+// evictLocked removes least-recently-used entries until the cache is
+// back under maxBytes. Callers must hold d.mu.
+func (d *DiskCache) evictLocked() {
+	if d.maxBytes <= 0 {
+		return
+	}
+	for d.size > d.maxBytes {
+		el := d.lru.Back()
+		if el == nil {
+			return
+		}
+		d.removeEntryLocked(el)
+	}
+}
+
+// removeEntryLocked deletes an entry's backing file and bookkeeping.
+// Callers must hold d.mu.
+func (d *DiskCache) removeEntryLocked(el *list.Element) {
+	entry := el.Value.(*diskCacheEntry)
+	os.Remove(entry.path)
+	d.forgetEntryLocked(el)
+}
+
+// forgetEntryLocked discards el's accounting without touching its
+// backing file, for the case where the file has already been replaced
+// by a new entry for the same key. Callers must hold d.mu.
+func (d *DiskCache) forgetEntryLocked(el *list.Element) {
+	entry := el.Value.(*diskCacheEntry)
+	d.size -= entry.size
+	delete(d.index, entry.key)
+	d.lru.Remove(el)
+}
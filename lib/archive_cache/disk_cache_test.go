@@ -0,0 +1,142 @@
+package archivecache
+
+import (
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newTestDiskCache(t *testing.T, maxBytes int64, ttl time.Duration) *DiskCache {
+	t.Helper()
+	dir, err := ioutil.TempDir("", "furan-diskcache")
+	if err != nil {
+		t.Fatalf("TempDir: %v", err)
+	}
+	t.Cleanup(func() {
+		if err := os.RemoveAll(dir); err != nil {
+			t.Errorf("cleanup %v: %v", dir, err)
+		}
+	})
+
+	dc, err := NewDiskCache(dir, maxBytes, ttl)
+	if err != nil {
+		t.Fatalf("NewDiskCache: %v", err)
+	}
+	return dc
+}
+
+func TestDiskCachePutGetRoundTrip(t *testing.T) {
+	dc := newTestDiskCache(t, 0, time.Hour)
+
+	if err := dc.Put("key1", strings.NewReader("hello world")); err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+
+	rc, ok, err := dc.Get("key1")
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	if !ok {
+		t.Fatal("Get: ok = false, want true")
+	}
+	defer rc.Close()
+
+	got, err := ioutil.ReadAll(rc)
+	if err != nil {
+		t.Fatalf("ReadAll: %v", err)
+	}
+	if string(got) != "hello world" {
+		t.Errorf("Get content = %q, want %q", got, "hello world")
+	}
+}
+
+func TestDiskCacheMiss(t *testing.T) {
+	dc := newTestDiskCache(t, 0, time.Hour)
+	_, ok, err := dc.Get("missing")
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	if ok {
+		t.Error("Get(missing): ok = true, want false")
+	}
+}
+
+func TestDiskCacheTTLExpiry(t *testing.T) {
+	dc := newTestDiskCache(t, 0, 10*time.Millisecond)
+
+	if err := dc.Put("key1", strings.NewReader("hello")); err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+
+	time.Sleep(50 * time.Millisecond)
+
+	_, ok, err := dc.Get("key1")
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	if ok {
+		t.Error("Get after TTL expiry: ok = true, want false")
+	}
+}
+
+func TestDiskCacheLRUEviction(t *testing.T) {
+	// Each entry is 5 bytes ("aaaaa" etc); cap the cache at 12 bytes so
+	// only two of three entries fit.
+	dc := newTestDiskCache(t, 12, time.Hour)
+
+	if err := dc.Put("a", strings.NewReader("aaaaa")); err != nil {
+		t.Fatalf("Put(a): %v", err)
+	}
+	if err := dc.Put("b", strings.NewReader("bbbbb")); err != nil {
+		t.Fatalf("Put(b): %v", err)
+	}
+
+	// Touch "a" so it becomes more recently used than "b".
+	if rc, ok, err := dc.Get("a"); err != nil || !ok {
+		t.Fatalf("Get(a): ok=%v err=%v", ok, err)
+	} else {
+		rc.Close()
+	}
+
+	// Adding "c" pushes the cache over its 12-byte cap; "b" is now the
+	// least-recently-used entry and should be evicted, not "a".
+	if err := dc.Put("c", strings.NewReader("ccccc")); err != nil {
+		t.Fatalf("Put(c): %v", err)
+	}
+
+	if _, ok, err := dc.Get("a"); err != nil || !ok {
+		t.Errorf("Get(a) after eviction: ok=%v err=%v, want ok=true", ok, err)
+	}
+	if _, ok, err := dc.Get("b"); err != nil || ok {
+		t.Errorf("Get(b) after eviction: ok=%v err=%v, want ok=false", ok, err)
+	}
+	if _, ok, err := dc.Get("c"); err != nil || !ok {
+		t.Errorf("Get(c) after eviction: ok=%v err=%v, want ok=true", ok, err)
+	}
+}
+
+func TestDiskCachePutOverwritesExistingKey(t *testing.T) {
+	dc := newTestDiskCache(t, 0, time.Hour)
+
+	if err := dc.Put("key1", strings.NewReader("first")); err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+	if err := dc.Put("key1", strings.NewReader("second")); err != nil {
+		t.Fatalf("Put (overwrite): %v", err)
+	}
+
+	rc, ok, err := dc.Get("key1")
+	if err != nil || !ok {
+		t.Fatalf("Get: ok=%v err=%v", ok, err)
+	}
+	defer rc.Close()
+	got, err := ioutil.ReadAll(rc)
+	if err != nil {
+		t.Fatalf("ReadAll: %v", err)
+	}
+	if string(got) != "second" {
+		t.Errorf("Get content = %q, want %q", got, "second")
+	}
+}
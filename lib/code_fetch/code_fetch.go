@@ -0,0 +1,47 @@
+// Package codefetch provides a registry that selects the right
+// githubfetch.CodeFetcher implementation for a given repo host, so a
+// single Furan binary can build from GitHub, GitLab, or any other
+// provider a CodeFetcher has been written for.
+package codefetch
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+
+	githubfetch "github.com/vaguiner7/furan/lib/github_fetch"
+)
+
+// Registry maps a repo host (e.g. "github.com", "gitlab.example.com") to
+// the CodeFetcher that should handle it.
+type Registry struct {
+	fetchers map[string]githubfetch.CodeFetcher
+}
+
+// NewRegistry returns an empty Registry. Use Register to populate it.
+func NewRegistry() *Registry {
+	return &Registry{fetchers: map[string]githubfetch.CodeFetcher{}}
+}
+
+// Register associates host with cf. host is matched case-insensitively
+// against the host portion of a repo URL, including any port.
+func (r *Registry) Register(host string, cf githubfetch.CodeFetcher) {
+	r.fetchers[strings.ToLower(host)] = cf
+}
+
+// For returns the CodeFetcher registered for repoURL's host.
+func (r *Registry) For(repoURL string) (githubfetch.CodeFetcher, error) {
+	u, err := url.Parse(repoURL)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing repo url: %v", err)
+	}
+	host := strings.ToLower(u.Host)
+	if host == "" {
+		return nil, fmt.Errorf("repo url has no host: %v", repoURL)
+	}
+	cf, ok := r.fetchers[host]
+	if !ok {
+		return nil, fmt.Errorf("no CodeFetcher registered for host: %v", host)
+	}
+	return cf, nil
+}
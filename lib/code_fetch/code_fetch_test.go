@@ -0,0 +1,65 @@
+package codefetch
+
+import (
+	"testing"
+
+	githubfetch "github.com/vaguiner7/furan/lib/github_fetch"
+	gitlabfetch "github.com/vaguiner7/furan/lib/gitlab_fetch"
+)
+
+// TestRegistryDispatchesByHost verifies a single Registry can hold a
+// GitHubFetcher and a GitLabFetcher side by side, registered under their
+// respective hosts, and route a repo URL to the fetcher registered for
+// it. This is the whole point of the registry: letting the rest of Furan
+// depend on githubfetch.CodeFetcher instead of a concrete fetcher type.
+func TestRegistryDispatchesByHost(t *testing.T) {
+	gh := githubfetch.NewGitHubFetcher("token")
+	gl, err := gitlabfetch.NewGitLabFetcher("", "token")
+	if err != nil {
+		t.Fatalf("NewGitLabFetcher: %v", err)
+	}
+
+	r := NewRegistry()
+	r.Register("github.com", gh)
+	r.Register("gitlab.com", gl)
+
+	cf, err := r.For("https://github.com/owner/repo")
+	if err != nil {
+		t.Fatalf("For(github.com): %v", err)
+	}
+	if cf != githubfetch.CodeFetcher(gh) {
+		t.Errorf("For(github.com) returned a different fetcher than was registered")
+	}
+
+	cf, err = r.For("https://gitlab.com/owner/repo")
+	if err != nil {
+		t.Fatalf("For(gitlab.com): %v", err)
+	}
+	if cf != githubfetch.CodeFetcher(gl) {
+		t.Errorf("For(gitlab.com) returned a different fetcher than was registered")
+	}
+}
+
+func TestRegistryHostIsCaseInsensitive(t *testing.T) {
+	gh := githubfetch.NewGitHubFetcher("token")
+	r := NewRegistry()
+	r.Register("GitHub.com", gh)
+
+	if _, err := r.For("https://github.com/owner/repo"); err != nil {
+		t.Errorf("For(lowercase host) after Register(mixed-case host): %v", err)
+	}
+}
+
+func TestRegistryUnregisteredHost(t *testing.T) {
+	r := NewRegistry()
+	if _, err := r.For("https://bitbucket.org/owner/repo"); err == nil {
+		t.Error("For(unregistered host) = nil error, want error")
+	}
+}
+
+func TestRegistryInvalidURL(t *testing.T) {
+	r := NewRegistry()
+	if _, err := r.For("://not a url"); err == nil {
+		t.Error("For(invalid url) = nil error, want error")
+	}
+}
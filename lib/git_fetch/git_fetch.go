@@ -0,0 +1,287 @@
+// Package gitfetch implements githubfetch.CodeFetcher by cloning a repo
+// directly with go-git instead of going through a provider's tarball API.
+// This avoids GitHub's Tarball API limits (rate limiting, the 1GB size
+// cap, no submodules, no partial history) and works against any
+// self-hosted or private repo reachable over HTTPS or SSH.
+package gitfetch
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/docker/docker/builder/dockerignore"
+	"github.com/docker/docker/pkg/archive"
+
+	git "github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/config"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/transport"
+
+	githubfetch "github.com/vaguiner7/furan/lib/github_fetch"
+	"gopkg.in/DataDog/dd-trace-go.v1/ddtrace/tracer"
+)
+
+const dockerIgnorePath = ".dockerignore"
+
+// GitFetcher implements githubfetch.CodeFetcher by performing a shallow
+// local clone of the repo and walking the resulting working tree. Like
+// GitHubFetcher/GitLabFetcher, "owner" and "repo" are the repo's identity
+// (e.g. an org and a name), not an opaque URL: GitFetcher joins them onto
+// BaseURL to build the clone URL, so a codefetch.Registry can hand any
+// CodeFetcher implementation the same two strings.
+type GitFetcher struct {
+	// BaseURL is the scheme+host (and optional path prefix) clone URLs
+	// are built under, e.g. "https://git.example.com" or
+	// "git@git.example.com:". A trailing slash is ignored.
+	BaseURL string
+	// Auth is used for both the clone and the remote ref lookup done by
+	// GetCommitSHA. It may be nil for anonymous HTTPS access.
+	Auth transport.AuthMethod
+	// RecurseSubmodules controls whether submodules are cloned along
+	// with the parent repo.
+	RecurseSubmodules bool
+}
+
+// NewGitFetcher returns a new go-git based fetcher that clones repos
+// under baseURL (e.g. "https://git.example.com"). auth may be nil for
+// anonymous HTTPS clones.
+func NewGitFetcher(baseURL string, auth transport.AuthMethod, recurseSubmodules bool) *GitFetcher {
+	return &GitFetcher{
+		BaseURL:           baseURL,
+		Auth:              auth,
+		RecurseSubmodules: recurseSubmodules,
+	}
+}
+
+// repoURL joins owner and repo onto gf.BaseURL the same way
+// GitHubFetcher/GitLabFetcher treat them as distinct path components,
+// rather than accepting a caller-supplied full URL.
+func (gf *GitFetcher) repoURL(owner, repo string) string {
+	base := strings.TrimSuffix(gf.BaseURL, "/")
+	if strings.HasSuffix(base, ":") {
+		// scp-like SSH form, e.g. "git@git.example.com:", has no "/"
+		// between host and path.
+		return base + owner + "/" + repo + ".git"
+	}
+	return base + "/" + owner + "/" + repo + ".git"
+}
+
+// submoduleRecursion returns the go-git submodule behavior matching gf's
+// RecurseSubmodules setting.
+func (gf *GitFetcher) submoduleRecursion() git.SubmoduleRescursivity {
+	if gf.RecurseSubmodules {
+		return git.DefaultSubmoduleRecursionDepth
+	}
+	return git.NoRecurseSubmodules
+}
+
+// resolvedRef is the outcome of resolving a ref against a remote: name is
+// set when ref matched an advertised branch or tag, hash is always set
+// to the commit SHA it resolves to.
+type resolvedRef struct {
+	name plumbing.ReferenceName
+	hash string
+}
+
+// resolveRef resolves ref against repoURL's remote the same way for both
+// GetCommitSHA and Get, using a ls-remote-style listing so no objects
+// need to be fetched just to find out what ref points at.
+func (gf *GitFetcher) resolveRef(repoURL, ref string) (resolvedRef, error) {
+	remote := git.NewRemote(nil, &config.RemoteConfig{
+		Name: "origin",
+		URLs: []string{repoURL},
+	})
+	refs, err := remote.List(&git.ListOptions{Auth: gf.Auth})
+	if err != nil {
+		return resolvedRef{}, fmt.Errorf("error listing remote refs: %v", err)
+	}
+
+	wantBranch := plumbing.NewBranchReferenceName(ref)
+	wantTag := plumbing.NewTagReferenceName(ref)
+	for _, r := range refs {
+		switch r.Name() {
+		case wantBranch, wantTag, plumbing.ReferenceName(ref):
+			return resolvedRef{name: r.Name(), hash: r.Hash().String()}, nil
+		}
+	}
+	// ref may already be a full commit SHA rather than a branch/tag
+	// name; accept it as-is. Abbreviated SHAs are not accepted.
+	if plumbing.IsHash(ref) {
+		return resolvedRef{hash: ref}, nil
+	}
+	return resolvedRef{}, fmt.Errorf("ref not found on remote: %v", ref)
+}
+
+// GetCommitSHA returns the commit SHA that ref resolves to on the remote.
+func (gf *GitFetcher) GetCommitSHA(parentSpan tracer.Span, owner string, repo string, ref string) (csha string, err error) {
+	span := tracer.StartSpan("git_fetcher.get_commit_sha", tracer.ChildOf(parentSpan.Context()))
+	defer func() {
+		span.Finish(tracer.WithError(err))
+	}()
+
+	rr, err := gf.resolveRef(gf.repoURL(owner, repo), ref)
+	if err != nil {
+		return "", err
+	}
+	return rr.hash, nil
+}
+
+// Get clones owner/repo at ref into a temp directory and returns the
+// working tree, dockerignore-filtered, as a stream in the archive format
+// requested by opts. The temp directory is removed once the stream has
+// been fully read or closed.
+//
+// ref is resolved the same way GetCommitSHA resolves it: a branch or tag
+// clones shallow (depth=1), since go-git can fetch those directly by
+// reference name; a raw commit SHA has no equivalent shallow path, so
+// Get instead clones full history and checks the commit out explicitly.
+func (gf *GitFetcher) Get(parentSpan tracer.Span, owner string, repo string, ref string, opts githubfetch.GetOptions) (tarball io.Reader, err error) {
+	span := tracer.StartSpan("git_fetcher.get", tracer.ChildOf(parentSpan.Context()))
+	defer func() {
+		span.Finish(tracer.WithError(err))
+	}()
+
+	repoURL := gf.repoURL(owner, repo)
+	rr, err := gf.resolveRef(repoURL, ref)
+	if err != nil {
+		return nil, err
+	}
+
+	dir, err := ioutil.TempDir("", "furan-gitfetch")
+	if err != nil {
+		return nil, fmt.Errorf("error creating temp dir: %v", err)
+	}
+
+	cloneOpts := &git.CloneOptions{
+		URL:               repoURL,
+		Auth:              gf.Auth,
+		RecurseSubmodules: gf.submoduleRecursion(),
+	}
+	if rr.name != "" {
+		cloneOpts.ReferenceName = rr.name
+		cloneOpts.SingleBranch = true
+		cloneOpts.Depth = 1
+		cloneOpts.Tags = git.NoTags
+	} else {
+		cloneOpts.Tags = git.AllTags
+	}
+
+	gitRepo, err := git.PlainClone(dir, false, cloneOpts)
+	if err != nil {
+		os.RemoveAll(dir)
+		return nil, fmt.Errorf("error cloning repo: %v", err)
+	}
+
+	if rr.name == "" {
+		wt, err := gitRepo.Worktree()
+		if err != nil {
+			os.RemoveAll(dir)
+			return nil, fmt.Errorf("error getting worktree: %v", err)
+		}
+		if err := wt.Checkout(&git.CheckoutOptions{Hash: plumbing.NewHash(rr.hash)}); err != nil {
+			os.RemoveAll(dir)
+			return nil, fmt.Errorf("error checking out commit %v: %v", rr.hash, err)
+		}
+	}
+
+	excludes, err := gf.parseDockerIgnoreIfExists(dir)
+	if err != nil {
+		os.RemoveAll(dir)
+		return nil, fmt.Errorf("error parsing %v file: %v", dockerIgnorePath, err)
+	}
+
+	return gf.archiveWorkingTree(dir, excludes, opts.Format)
+}
+
+// parseDockerIgnoreIfExists parses the .dockerignore file at the root of
+// the cloned working tree, if one exists.
+func (gf *GitFetcher) parseDockerIgnoreIfExists(dir string) ([]string, error) {
+	f, err := os.Open(filepath.Join(dir, dockerIgnorePath))
+	if err != nil {
+		if os.IsNotExist(err) {
+			// Not all repos will have dockerignore, just move along
+			return []string{}, nil
+		}
+		return nil, fmt.Errorf("error opening .dockerignore: %v", err)
+	}
+	defer f.Close()
+	excludes, err := dockerignore.ReadAll(f)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing %v: %v", dockerIgnorePath, err)
+	}
+	return excludes, nil
+}
+
+// archiveWorkingTree walks dir and produces a stream of its contents
+// (excluding the .git directory) in the requested format, filtering out
+// any dockerignored paths as it goes, reusing the same archive machinery
+// the rest of Furan's build pipeline already depends on. The temp
+// directory is removed once the stream has been fully drained.
+func (gf *GitFetcher) archiveWorkingTree(dir string, excludes []string, format githubfetch.ArchiveFormat) (io.Reader, error) {
+	tarball, err := archive.TarWithOptions(dir, &archive.TarOptions{
+		ExcludePatterns: append(excludes, ".git"),
+	})
+	if err != nil {
+		os.RemoveAll(dir)
+		return nil, fmt.Errorf("error archiving working tree: %v", err)
+	}
+
+	pr, pw := io.Pipe()
+	go func() {
+		defer os.RemoveAll(dir)
+		defer tarball.Close()
+		pw.CloseWithError(writeArchive(pw, tarball, format))
+	}()
+	return pr, nil
+}
+
+// writeArchive transcodes the tar stream read from src into w, in the
+// requested format.
+func writeArchive(w io.Writer, src io.Reader, format githubfetch.ArchiveFormat) error {
+	if format == githubfetch.FormatTar {
+		_, err := io.Copy(w, src)
+		return err
+	}
+	if format == githubfetch.FormatTarGz {
+		gzw := gzip.NewWriter(w)
+		if _, err := io.Copy(gzw, src); err != nil {
+			return err
+		}
+		return gzw.Close()
+	}
+
+	// FormatZip: re-tar entries into a zip archive.
+	tr := tar.NewReader(src)
+	zw := zip.NewWriter(w)
+	for {
+		h, err := tr.Next()
+		if err == io.EOF {
+			return zw.Close()
+		}
+		if err != nil {
+			return err
+		}
+		fh := &zip.FileHeader{Name: h.Name, Method: zip.Deflate, Modified: h.ModTime}
+		if h.Typeflag == tar.TypeDir {
+			fh.Name += "/"
+			fh.Method = zip.Store
+		}
+		fh.SetMode(os.FileMode(h.Mode))
+		ew, err := zw.CreateHeader(fh)
+		if err != nil {
+			return err
+		}
+		if _, err := io.Copy(ew, tr); err != nil {
+			return err
+		}
+	}
+}
+
+var _ githubfetch.CodeFetcher = (*GitFetcher)(nil)
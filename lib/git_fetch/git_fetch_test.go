@@ -0,0 +1,196 @@
+package gitfetch
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"io"
+	"io/ioutil"
+	"testing"
+
+	git "github.com/go-git/go-git/v5"
+
+	githubfetch "github.com/vaguiner7/furan/lib/github_fetch"
+)
+
+// TestRepoURL verifies owner/repo are joined onto BaseURL consistently
+// by repoURL, which GetCommitSHA and Get both use to build clone URLs,
+// for both the plain-HTTPS and scp-like SSH forms: owner/repo are treated
+// as two distinct identity components, the way GitHubFetcher/GitLabFetcher
+// treat them, not a caller-supplied URL.
+func TestRepoURL(t *testing.T) {
+	cases := []struct {
+		name    string
+		baseURL string
+		owner   string
+		repo    string
+		want    string
+	}{
+		{
+			name:    "https",
+			baseURL: "https://git.example.com",
+			owner:   "myorg",
+			repo:    "myrepo",
+			want:    "https://git.example.com/myorg/myrepo.git",
+		},
+		{
+			name:    "https trailing slash",
+			baseURL: "https://git.example.com/",
+			owner:   "myorg",
+			repo:    "myrepo",
+			want:    "https://git.example.com/myorg/myrepo.git",
+		},
+		{
+			name:    "scp-like ssh",
+			baseURL: "git@git.example.com:",
+			owner:   "myorg",
+			repo:    "myrepo",
+			want:    "git@git.example.com:myorg/myrepo.git",
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			gf := NewGitFetcher(c.baseURL, nil, false)
+			if got := gf.repoURL(c.owner, c.repo); got != c.want {
+				t.Errorf("repoURL(%q, %q) = %q, want %q", c.owner, c.repo, got, c.want)
+			}
+		})
+	}
+}
+
+func TestSubmoduleRecursion(t *testing.T) {
+	recurse := NewGitFetcher("https://git.example.com", nil, true)
+	if got := recurse.submoduleRecursion(); got != git.DefaultSubmoduleRecursionDepth {
+		t.Errorf("RecurseSubmodules=true: submoduleRecursion() = %v, want DefaultSubmoduleRecursionDepth", got)
+	}
+
+	noRecurse := NewGitFetcher("https://git.example.com", nil, false)
+	if got := noRecurse.submoduleRecursion(); got != git.NoRecurseSubmodules {
+		t.Errorf("RecurseSubmodules=false: submoduleRecursion() = %v, want NoRecurseSubmodules", got)
+	}
+}
+
+// TestWriteArchiveRoundTrip writes a small tar stream through writeArchive
+// for each supported ArchiveFormat and verifies the resulting stream can
+// be read back with the matching stdlib reader and reproduces the
+// original names and content.
+func TestWriteArchiveRoundTrip(t *testing.T) {
+	type entry struct {
+		name    string
+		content string
+	}
+	entries := []entry{
+		{name: "README.md", content: "hello world"},
+		{name: "dir/nested.txt", content: "nested content"},
+	}
+
+	var src bytes.Buffer
+	tw := tar.NewWriter(&src)
+	for _, e := range entries {
+		h := &tar.Header{Name: e.name, Mode: 0644, Size: int64(len(e.content))}
+		if err := tw.WriteHeader(h); err != nil {
+			t.Fatalf("WriteHeader(%v): %v", e.name, err)
+		}
+		if _, err := tw.Write([]byte(e.content)); err != nil {
+			t.Fatalf("Write(%v): %v", e.name, err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatalf("tar Close: %v", err)
+	}
+
+	formats := []githubfetch.ArchiveFormat{githubfetch.FormatTar, githubfetch.FormatTarGz, githubfetch.FormatZip}
+	for _, format := range formats {
+		format := format
+		t.Run(formatName(format), func(t *testing.T) {
+			var out bytes.Buffer
+			if err := writeArchive(&out, bytes.NewReader(src.Bytes()), format); err != nil {
+				t.Fatalf("writeArchive: %v", err)
+			}
+
+			got := readEntries(t, format, out.Bytes())
+			if len(got) != len(entries) {
+				t.Fatalf("got %v entries, want %v", len(got), len(entries))
+			}
+			for i, e := range entries {
+				if got[i].name != e.name {
+					t.Errorf("entry %v: name = %v, want %v", i, got[i].name, e.name)
+				}
+				if got[i].content != e.content {
+					t.Errorf("entry %v: content = %q, want %q", i, got[i].content, e.content)
+				}
+			}
+		})
+	}
+}
+
+func formatName(f githubfetch.ArchiveFormat) string {
+	switch f {
+	case githubfetch.FormatTarGz:
+		return "tar.gz"
+	case githubfetch.FormatTar:
+		return "tar"
+	case githubfetch.FormatZip:
+		return "zip"
+	default:
+		return "unknown"
+	}
+}
+
+type readEntry struct {
+	name    string
+	content string
+}
+
+func readEntries(t *testing.T, format githubfetch.ArchiveFormat, data []byte) []readEntry {
+	t.Helper()
+
+	var entries []readEntry
+	if format == githubfetch.FormatZip {
+		zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+		if err != nil {
+			t.Fatalf("zip.NewReader: %v", err)
+		}
+		for _, f := range zr.File {
+			rc, err := f.Open()
+			if err != nil {
+				t.Fatalf("open %v: %v", f.Name, err)
+			}
+			content, err := ioutil.ReadAll(rc)
+			rc.Close()
+			if err != nil {
+				t.Fatalf("read %v: %v", f.Name, err)
+			}
+			entries = append(entries, readEntry{name: f.Name, content: string(content)})
+		}
+		return entries
+	}
+
+	r := io.Reader(bytes.NewReader(data))
+	if format == githubfetch.FormatTarGz {
+		gzr, err := gzip.NewReader(r)
+		if err != nil {
+			t.Fatalf("gzip.NewReader: %v", err)
+		}
+		defer gzr.Close()
+		r = gzr
+	}
+	tr := tar.NewReader(r)
+	for {
+		h, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("tar Next: %v", err)
+		}
+		content, err := ioutil.ReadAll(tr)
+		if err != nil {
+			t.Fatalf("read %v: %v", h.Name, err)
+		}
+		entries = append(entries, readEntry{name: h.Name, content: string(content)})
+	}
+	return entries
+}
@@ -2,6 +2,7 @@ package githubfetch
 
 import (
 	"archive/tar"
+	"archive/zip"
 	"compress/gzip"
 	"context"
 	"fmt"
@@ -10,6 +11,7 @@ import (
 	"log"
 	"net/http"
 	"net/url"
+	"os"
 	"path"
 	"strings"
 	"time"
@@ -27,16 +29,48 @@ const (
 	dockerIgnorePath          = ".dockerignore"
 )
 
-// CodeFetcher represents an object capable of fetching code and returning a
-// gzip-compressed tarball io.Reader
+// ArchiveFormat identifies the container format CodeFetcher.Get should
+// produce its output stream in.
+type ArchiveFormat int
+
+const (
+	// FormatTarGz is a gzip-compressed tar stream. This is the
+	// zero-value/default format, matching CodeFetcher's original
+	// behavior.
+	FormatTarGz ArchiveFormat = iota
+	// FormatTar is an uncompressed tar stream.
+	FormatTar
+	// FormatZip is a zip archive.
+	FormatZip
+)
+
+// GetOptions controls how CodeFetcher.Get produces its output stream.
+type GetOptions struct {
+	// Format selects the archive container format. The zero value is
+	// FormatTarGz.
+	Format ArchiveFormat
+	// ResolveLFS, when true, replaces Git LFS pointer files encountered
+	// in the archive with their real object contents, fetched from the
+	// repo's LFS endpoint. Only honored by GitHubFetcher.
+	ResolveLFS bool
+	// LFSConcurrency bounds how many LFS objects are downloaded in
+	// parallel when ResolveLFS is set. Defaults to
+	// defaultLFSConcurrency when <= 0.
+	LFSConcurrency int
+}
+
+// CodeFetcher represents an object capable of fetching code and returning
+// the processed contents as an io.Reader, in the archive format
+// requested via GetOptions.
 type CodeFetcher interface {
 	GetCommitSHA(tracer.Span, string, string, string) (string, error)
-	Get(tracer.Span, string, string, string) (io.Reader, error)
+	Get(tracer.Span, string, string, string, GetOptions) (io.Reader, error)
 }
 
 // GitHubFetcher represents a github data fetcher
 type GitHubFetcher struct {
-	c *github.Client
+	c     *github.Client
+	token string
 }
 
 // NewGitHubFetcher returns a new github fetcher
@@ -44,7 +78,8 @@ func NewGitHubFetcher(token string) *GitHubFetcher {
 	ts := oauth2.StaticTokenSource(&oauth2.Token{AccessToken: token})
 	tc := oauth2.NewClient(oauth2.NoContext, ts)
 	gf := &GitHubFetcher{
-		c: github.NewClient(tc),
+		c:     github.NewClient(tc),
+		token: token,
 	}
 	return gf
 }
@@ -62,8 +97,8 @@ func (gf *GitHubFetcher) GetCommitSHA(parentSpan tracer.Span, owner string, repo
 }
 
 // Get fetches contents of GitHub repo and returns the processed contents as
-// an in-memory io.Reader.
-func (gf *GitHubFetcher) Get(parentSpan tracer.Span, owner string, repo string, ref string) (tarball io.Reader, err error) {
+// an in-memory io.Reader, in the archive format requested by opts.
+func (gf *GitHubFetcher) Get(parentSpan tracer.Span, owner string, repo string, ref string, opts GetOptions) (tarball io.Reader, err error) {
 	span := tracer.StartSpan("github_fetcher.get", tracer.ChildOf(parentSpan.Context()))
 	defer func() {
 		span.Finish(tracer.WithError(err))
@@ -88,7 +123,19 @@ func (gf *GitHubFetcher) Get(parentSpan tracer.Span, owner string, repo string,
 	if url == nil {
 		return nil, fmt.Errorf("url is nil")
 	}
-	return gf.getArchive(url, excludes)
+	if opts.ResolveLFS {
+		return gf.getArchiveWithLFS(ctx, owner, repo, url, excludes, opts)
+	}
+	return gf.getArchive(url, excludes, opts.Format)
+}
+
+// NewTarPrefixStripper wraps tarball in the same prefix-stripping/
+// dockerignore-filtering pipeline GitHubFetcher uses, transcoding it to
+// format, so other CodeFetcher implementations (e.g. gitlabfetch) can
+// normalize their archives the same way instead of duplicating the
+// logic.
+func NewTarPrefixStripper(tarball io.ReadCloser, excludes []string, format ArchiveFormat) io.Reader {
+	return newTarPrefixStripper(tarball, excludes, format)
 }
 
 // parseDockerIgnoreIfExists will parse the docker ignore file if it exists in order to determine which patterns should be excluded.
@@ -113,7 +160,18 @@ func (gf *GitHubFetcher) parseDockerIgnoreIfExists(ctx context.Context, owner, r
 	return excludes, nil
 }
 
-func (gf *GitHubFetcher) getArchive(archiveURL *url.URL, excludes []string) (io.Reader, error) {
+func (gf *GitHubFetcher) getArchive(archiveURL *url.URL, excludes []string, format ArchiveFormat) (io.Reader, error) {
+	body, err := gf.fetchArchiveBody(archiveURL)
+	if err != nil {
+		return nil, err
+	}
+	return newTarPrefixStripper(body, excludes, format), nil
+}
+
+// fetchArchiveBody issues the http GET for archiveURL and returns the
+// response body, which callers must close once they're done reading
+// (the tarPrefixStripper pipeline does this itself).
+func (gf *GitHubFetcher) fetchArchiveBody(archiveURL *url.URL) (io.ReadCloser, error) {
 	hc := http.Client{
 		Timeout: githubDownloadTimeoutSecs * time.Second,
 	}
@@ -131,7 +189,7 @@ func (gf *GitHubFetcher) getArchive(archiveURL *url.URL, excludes []string) (io.
 	if resp.StatusCode > 299 {
 		return nil, fmt.Errorf("archive http request failed: %v", resp.StatusCode)
 	}
-	return newTarPrefixStripper(resp.Body, excludes), nil
+	return resp.Body, nil
 }
 
 func (gf *GitHubFetcher) debugWriteTar(contents []byte) {
@@ -145,22 +203,25 @@ func (gf *GitHubFetcher) debugWriteTar(contents []byte) {
 }
 
 // tarPrefixStripper removes a random path that Github prefixes its
-// archives with.
+// archives with, and transcodes the result into the requested
+// ArchiveFormat.
 type tarPrefixStripper struct {
 	tarball          io.ReadCloser
 	pipeReader       *io.PipeReader
 	pipeWriter       *io.PipeWriter
 	strippingStarted bool
 	excludes         []string
+	format           ArchiveFormat
 }
 
-func newTarPrefixStripper(tarball io.ReadCloser, excludes []string) io.Reader {
+func newTarPrefixStripper(tarball io.ReadCloser, excludes []string, format ArchiveFormat) io.Reader {
 	reader, writer := io.Pipe()
 	return &tarPrefixStripper{
 		tarball:    tarball,
 		pipeReader: reader,
 		pipeWriter: writer,
 		excludes:   excludes,
+		format:     format,
 	}
 }
 
@@ -199,6 +260,80 @@ func (t *tarPrefixStripper) processHeader(h *tar.Header) (bool, error) {
 	return t.shouldSkipDockerIgnoredFile(h)
 }
 
+// archiveWriter abstracts over the container formats startStrippingPipe
+// can transcode a tar entry into.
+type archiveWriter interface {
+	writeEntry(h *tar.Header, content io.Reader) error
+	Close() error
+}
+
+func newArchiveWriter(w io.Writer, format ArchiveFormat) archiveWriter {
+	switch format {
+	case FormatZip:
+		return &zipWriter{zw: zip.NewWriter(w)}
+	case FormatTar:
+		return &tarWriter{tw: tar.NewWriter(w)}
+	default:
+		gzw := gzip.NewWriter(w)
+		return &tarWriter{tw: tar.NewWriter(gzw), gz: gzw}
+	}
+}
+
+// tarWriter writes entries as a tar stream, optionally gzip-compressed.
+type tarWriter struct {
+	tw *tar.Writer
+	gz *gzip.Writer
+}
+
+func (w *tarWriter) writeEntry(h *tar.Header, content io.Reader) error {
+	if err := w.tw.WriteHeader(h); err != nil {
+		return err
+	}
+	if _, err := io.Copy(w.tw, content); err != nil {
+		return err
+	}
+	return w.tw.Flush()
+}
+
+func (w *tarWriter) Close() error {
+	if err := w.tw.Close(); err != nil {
+		return err
+	}
+	if w.gz != nil {
+		return w.gz.Close()
+	}
+	return nil
+}
+
+// zipWriter writes entries as a zip archive, translating tar.Header
+// metadata into the equivalent zip.FileHeader.
+type zipWriter struct {
+	zw *zip.Writer
+}
+
+func (w *zipWriter) writeEntry(h *tar.Header, content io.Reader) error {
+	fh := &zip.FileHeader{
+		Name:     h.Name,
+		Method:   zip.Deflate,
+		Modified: h.ModTime,
+	}
+	if h.Typeflag == tar.TypeDir {
+		fh.Name += "/"
+		fh.Method = zip.Store
+	}
+	fh.SetMode(os.FileMode(h.Mode))
+	ew, err := w.zw.CreateHeader(fh)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(ew, content)
+	return err
+}
+
+func (w *zipWriter) Close() error {
+	return w.zw.Close()
+}
+
 func (t *tarPrefixStripper) startStrippingPipe() {
 	gzr, err := gzip.NewReader(t.tarball)
 	if err != nil {
@@ -206,17 +341,17 @@ func (t *tarPrefixStripper) startStrippingPipe() {
 		return
 	}
 
-	tarball := tar.NewReader(gzr)
-	outTarball := tar.NewWriter(t.pipeWriter)
+	inTarball := tar.NewReader(gzr)
+	out := newArchiveWriter(t.pipeWriter, t.format)
 
 	closeFunc := func(e error) {
-		outTarball.Close()
+		out.Close()
 		t.pipeWriter.CloseWithError(e)
 		t.tarball.Close()
 	}
 
 	for {
-		header, err := tarball.Next()
+		header, err := inTarball.Next()
 		if err == io.EOF {
 			closeFunc(nil)
 			return
@@ -235,15 +370,7 @@ func (t *tarPrefixStripper) startStrippingPipe() {
 			continue
 		}
 
-		if err := outTarball.WriteHeader(header); err != nil {
-			closeFunc(err)
-			return
-		}
-		if _, err := io.Copy(outTarball, tarball); err != nil {
-			closeFunc(err)
-			return
-		}
-		if err := outTarball.Flush(); err != nil {
+		if err := out.writeEntry(header, inTarball); err != nil {
 			closeFunc(err)
 			return
 		}
@@ -0,0 +1,135 @@
+package githubfetch
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"io"
+	"io/ioutil"
+	"testing"
+)
+
+// TestArchiveWriterRoundTrip writes the same set of entries through
+// newArchiveWriter for each supported ArchiveFormat and verifies the
+// resulting stream can be read back with the matching stdlib reader and
+// reproduces the original names and content.
+func TestArchiveWriterRoundTrip(t *testing.T) {
+	type entry struct {
+		name    string
+		mode    int64
+		content string
+	}
+	entries := []entry{
+		{name: "README.md", mode: 0644, content: "hello world"},
+		{name: "dir/nested.txt", mode: 0755, content: "nested content"},
+		{name: "empty.txt", mode: 0644, content: ""},
+	}
+
+	formats := []ArchiveFormat{FormatTarGz, FormatTar, FormatZip}
+
+	for _, format := range formats {
+		format := format
+		t.Run(formatName(format), func(t *testing.T) {
+			var buf bytes.Buffer
+			w := newArchiveWriter(&buf, format)
+			for _, e := range entries {
+				h := &tar.Header{
+					Name: e.name,
+					Mode: e.mode,
+					Size: int64(len(e.content)),
+				}
+				if err := w.writeEntry(h, bytes.NewBufferString(e.content)); err != nil {
+					t.Fatalf("writeEntry(%v): %v", e.name, err)
+				}
+			}
+			if err := w.Close(); err != nil {
+				t.Fatalf("Close: %v", err)
+			}
+
+			got := readArchive(t, format, buf.Bytes())
+			if len(got) != len(entries) {
+				t.Fatalf("got %v entries, want %v", len(got), len(entries))
+			}
+			for i, e := range entries {
+				if got[i].name != e.name {
+					t.Errorf("entry %v: name = %v, want %v", i, got[i].name, e.name)
+				}
+				if got[i].content != e.content {
+					t.Errorf("entry %v: content = %q, want %q", i, got[i].content, e.content)
+				}
+			}
+		})
+	}
+}
+
+func formatName(f ArchiveFormat) string {
+	switch f {
+	case FormatTarGz:
+		return "tar.gz"
+	case FormatTar:
+		return "tar"
+	case FormatZip:
+		return "zip"
+	default:
+		return "unknown"
+	}
+}
+
+type readEntry struct {
+	name    string
+	content string
+}
+
+// readArchive reads back an archive produced by newArchiveWriter using
+// the stdlib reader matching format.
+func readArchive(t *testing.T, format ArchiveFormat, data []byte) []readEntry {
+	t.Helper()
+
+	var entries []readEntry
+	switch format {
+	case FormatZip:
+		zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+		if err != nil {
+			t.Fatalf("zip.NewReader: %v", err)
+		}
+		for _, f := range zr.File {
+			rc, err := f.Open()
+			if err != nil {
+				t.Fatalf("open %v: %v", f.Name, err)
+			}
+			content, err := ioutil.ReadAll(rc)
+			rc.Close()
+			if err != nil {
+				t.Fatalf("read %v: %v", f.Name, err)
+			}
+			entries = append(entries, readEntry{name: f.Name, content: string(content)})
+		}
+	default:
+		r := io.Reader(bytes.NewReader(data))
+		if format == FormatTarGz {
+			gzr, err := gzip.NewReader(r)
+			if err != nil {
+				t.Fatalf("gzip.NewReader: %v", err)
+			}
+			defer gzr.Close()
+			r = gzr
+		}
+		tr := tar.NewReader(r)
+		for {
+			h, err := tr.Next()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				t.Fatalf("tar Next: %v", err)
+			}
+			content, err := ioutil.ReadAll(tr)
+			if err != nil {
+				t.Fatalf("read %v: %v", h.Name, err)
+			}
+			entries = append(entries, readEntry{name: h.Name, content: string(content)})
+		}
+	}
+	return entries
+}
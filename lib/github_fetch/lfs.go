@@ -0,0 +1,430 @@
+package githubfetch
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// defaultLFSConcurrency bounds how many LFS objects are downloaded
+	// in parallel when GetOptions.LFSConcurrency isn't set.
+	defaultLFSConcurrency = 4
+	// lfsPointerMaxSize is the largest size a tar entry can be and
+	// still plausibly be an LFS pointer file rather than real content.
+	lfsPointerMaxSize      = 1024
+	lfsDownloadTimeoutSecs = 300
+)
+
+var lfsPointerRe = regexp.MustCompile(`(?s)\Aversion https://git-lfs\.github\.com/spec/v1\noid sha256:([0-9a-f]{64})\nsize (\d+)\n\z`)
+
+// lfsPointer is an LFS pointer file found while scanning the archive.
+type lfsPointer struct {
+	path string
+	oid  string
+	size int64
+}
+
+// parseLFSPointer reports whether data is a well-formed LFS pointer file,
+// returning its OID and size if so.
+func parseLFSPointer(data []byte) (oid string, size int64, ok bool) {
+	m := lfsPointerRe.FindSubmatch(data)
+	if m == nil {
+		return "", 0, false
+	}
+	size, err := strconv.ParseInt(string(m[2]), 10, 64)
+	if err != nil {
+		return "", 0, false
+	}
+	return string(m[1]), size, true
+}
+
+// lfsObject is a resolved download location for an LFS pointer's real
+// content.
+type lfsObject struct {
+	oid    string
+	size   int64
+	href   string
+	header map[string]string
+}
+
+// lfsResult is the outcome of downloading a prefetched LFS object (or
+// failing to).
+type lfsResult struct {
+	path string
+	err  error
+}
+
+// lfsResultHolder lets every tar entry that references the same OID
+// (a single LFS blob checked into the repo at more than one path is
+// common) observe the same download outcome: done is closed exactly
+// once, after result is written, so any number of receivers can wait on
+// it without racing or blocking past the first. refsRemaining tracks how
+// many entries still need the temp file at result.path, so it's only
+// removed once the last of them has consumed it.
+type lfsResultHolder struct {
+	done          chan struct{}
+	result        lfsResult
+	refsRemaining int32
+}
+
+// getArchiveWithLFS scans the archive for LFS pointer files, resolves
+// their real objects via the repo's LFS batch endpoint, kicks off
+// concurrency-limited downloads of those objects in the background, then
+// re-streams the archive with pointer entries spliced out in favor of
+// the (by-then likely already downloaded) real object bytes.
+func (gf *GitHubFetcher) getArchiveWithLFS(ctx context.Context, owner, repo string, archiveURL *url.URL, excludes []string, opts GetOptions) (io.Reader, error) {
+	pointers, err := gf.scanLFSPointers(archiveURL, excludes)
+	if err != nil {
+		return nil, fmt.Errorf("error scanning archive for lfs pointers: %v", err)
+	}
+	if len(pointers) == 0 {
+		return gf.getArchive(archiveURL, excludes, opts.Format)
+	}
+
+	objects, err := gf.resolveLFSObjects(ctx, owner, repo, pointers)
+	if err != nil {
+		return nil, fmt.Errorf("error resolving lfs objects: %v", err)
+	}
+
+	refCounts := make(map[string]int32, len(objects))
+	for _, p := range pointers {
+		refCounts[p.oid]++
+	}
+
+	concurrency := opts.LFSConcurrency
+	if concurrency <= 0 {
+		concurrency = defaultLFSConcurrency
+	}
+	results := prefetchLFSObjects(objects, refCounts, concurrency)
+
+	body, err := gf.fetchArchiveBody(archiveURL)
+	if err != nil {
+		return nil, err
+	}
+	return streamWithLFSResults(body, excludes, opts.Format, results), nil
+}
+
+// scanLFSPointers does a throwaway pass over the archive, applying the
+// same prefix-stripping/dockerignore rules as the real pass, and
+// collects every small entry whose content is an LFS pointer file.
+func (gf *GitHubFetcher) scanLFSPointers(archiveURL *url.URL, excludes []string) ([]lfsPointer, error) {
+	body, err := gf.fetchArchiveBody(archiveURL)
+	if err != nil {
+		return nil, err
+	}
+	defer body.Close()
+
+	gzr, err := gzip.NewReader(body)
+	if err != nil {
+		return nil, err
+	}
+	t := &tarPrefixStripper{excludes: excludes}
+	tr := tar.NewReader(gzr)
+
+	var pointers []lfsPointer
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return pointers, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		skip, err := t.processHeader(header)
+		if err != nil {
+			return nil, err
+		}
+		if skip || header.Typeflag != tar.TypeReg || header.Size > lfsPointerMaxSize {
+			continue
+		}
+		content, err := ioutil.ReadAll(tr)
+		if err != nil {
+			return nil, err
+		}
+		if oid, size, ok := parseLFSPointer(content); ok {
+			pointers = append(pointers, lfsPointer{path: header.Name, oid: oid, size: size})
+		}
+	}
+}
+
+// resolveLFSObjects resolves download locations for pointers in a single
+// batch call to the repo's LFS endpoint.
+func (gf *GitHubFetcher) resolveLFSObjects(ctx context.Context, owner, repo string, pointers []lfsPointer) (map[string]lfsObject, error) {
+	type batchObj struct {
+		OID  string `json:"oid"`
+		Size int64  `json:"size"`
+	}
+	reqObjs := make([]batchObj, len(pointers))
+	for i, p := range pointers {
+		reqObjs[i] = batchObj{OID: p.oid, Size: p.size}
+	}
+	reqBody, err := json.Marshal(map[string]interface{}{
+		"operation": "download",
+		"transfers": []string{"basic"},
+		"objects":   reqObjs,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	endpoint := fmt.Sprintf("https://github.com/%s/%s.git/info/lfs/objects/batch", owner, repo)
+	hr, err := http.NewRequestWithContext(ctx, "POST", endpoint, bytes.NewReader(reqBody))
+	if err != nil {
+		return nil, err
+	}
+	hr.Header.Set("Content-Type", "application/vnd.git-lfs+json")
+	hr.Header.Set("Accept", "application/vnd.git-lfs+json")
+	if gf.token != "" {
+		hr.SetBasicAuth("x-access-token", gf.token)
+	}
+
+	hc := http.Client{Timeout: lfsDownloadTimeoutSecs * time.Second}
+	resp, err := hc.Do(hr)
+	if err != nil {
+		return nil, fmt.Errorf("error performing lfs batch request: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode > 299 {
+		return nil, fmt.Errorf("lfs batch request failed: %v", resp.StatusCode)
+	}
+
+	var batchResp struct {
+		Objects []struct {
+			OID   string `json:"oid"`
+			Size  int64  `json:"size"`
+			Error *struct {
+				Code    int    `json:"code"`
+				Message string `json:"message"`
+			} `json:"error"`
+			Actions struct {
+				Download struct {
+					Href   string            `json:"href"`
+					Header map[string]string `json:"header"`
+				} `json:"download"`
+			} `json:"actions"`
+		} `json:"objects"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&batchResp); err != nil {
+		return nil, fmt.Errorf("error decoding lfs batch response: %v", err)
+	}
+
+	objects := make(map[string]lfsObject, len(batchResp.Objects))
+	for _, o := range batchResp.Objects {
+		if o.Error != nil {
+			return nil, fmt.Errorf("lfs object %v: %v", o.OID, o.Error.Message)
+		}
+		objects[o.OID] = lfsObject{
+			oid:    o.OID,
+			size:   o.Size,
+			href:   o.Actions.Download.Href,
+			header: o.Actions.Download.Header,
+		}
+	}
+	return objects, nil
+}
+
+// prefetchLFSObjects starts downloading every object in objects to a
+// temp file, at most concurrency at a time, and returns a result holder
+// per OID that the streaming pass can wait on once it reaches the
+// corresponding pointer entry. Downloads that lose the race to the
+// streaming pass are already complete or in flight by the time they're
+// needed, rather than starting cold. refCounts records how many tar
+// entries reference each OID, so its temp file can be cleaned up once
+// the last one has consumed it.
+func prefetchLFSObjects(objects map[string]lfsObject, refCounts map[string]int32, concurrency int) map[string]*lfsResultHolder {
+	results := make(map[string]*lfsResultHolder, len(objects))
+	sem := make(chan struct{}, concurrency)
+
+	for _, obj := range objects {
+		holder := &lfsResultHolder{
+			done:          make(chan struct{}),
+			refsRemaining: refCounts[obj.oid],
+		}
+		results[obj.oid] = holder
+		go func(obj lfsObject, holder *lfsResultHolder) {
+			sem <- struct{}{}
+			defer func() { <-sem }()
+			path, err := downloadLFSObjectToTemp(obj)
+			holder.result = lfsResult{path: path, err: err}
+			close(holder.done)
+		}(obj, holder)
+	}
+	return results
+}
+
+// downloadLFSObjectToTemp downloads obj's real content to a temp file
+// and returns its path.
+func downloadLFSObjectToTemp(obj lfsObject) (string, error) {
+	body, err := fetchLFSObject(obj)
+	if err != nil {
+		return "", err
+	}
+	defer body.Close()
+
+	f, err := ioutil.TempFile("", "furan-lfs-")
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	if _, err := io.Copy(f, body); err != nil {
+		os.Remove(f.Name())
+		return "", err
+	}
+	return f.Name(), nil
+}
+
+// fetchLFSObject performs the actual LFS object download described by
+// obj.
+func fetchLFSObject(obj lfsObject) (io.ReadCloser, error) {
+	hr, err := http.NewRequest("GET", obj.href, nil)
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range obj.header {
+		hr.Header.Set(k, v)
+	}
+	hc := http.Client{Timeout: lfsDownloadTimeoutSecs * time.Second}
+	resp, err := hc.Do(hr)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode > 299 {
+		resp.Body.Close()
+		return nil, fmt.Errorf("lfs object download failed: %v", resp.StatusCode)
+	}
+	return resp.Body, nil
+}
+
+// streamWithLFSResults re-runs the prefix-stripping/dockerignore pass
+// over tarball. Entries that are LFS pointers are replaced with the
+// contents of their prefetched temp file (header.Size rewritten to the
+// real size); any per-object download error surfaces through the pipe
+// writer like any other streaming error.
+func streamWithLFSResults(tarball io.ReadCloser, excludes []string, format ArchiveFormat, results map[string]*lfsResultHolder) io.Reader {
+	reader, writer := io.Pipe()
+	t := &tarPrefixStripper{
+		tarball:    tarball,
+		pipeReader: reader,
+		pipeWriter: writer,
+		excludes:   excludes,
+		format:     format,
+	}
+	go t.startStrippingPipeWithLFS(results)
+	t.strippingStarted = true
+	return t.pipeReader
+}
+
+func (t *tarPrefixStripper) startStrippingPipeWithLFS(results map[string]*lfsResultHolder) {
+	gzr, err := gzip.NewReader(t.tarball)
+	if err != nil {
+		t.pipeWriter.CloseWithError(err)
+		return
+	}
+	inTarball := tar.NewReader(gzr)
+	out := newArchiveWriter(t.pipeWriter, t.format)
+
+	closeFunc := func(e error) {
+		out.Close()
+		t.pipeWriter.CloseWithError(e)
+		t.tarball.Close()
+	}
+
+	for {
+		header, err := inTarball.Next()
+		if err == io.EOF {
+			closeFunc(nil)
+			return
+		}
+		if err != nil {
+			closeFunc(err)
+			return
+		}
+
+		skip, err := t.processHeader(header)
+		if err != nil {
+			closeFunc(err)
+			return
+		}
+		if skip {
+			continue
+		}
+
+		content, cleanup, err := entryContent(header, inTarball, results)
+		if err != nil {
+			closeFunc(err)
+			return
+		}
+		if err := out.writeEntry(header, content); err != nil {
+			if cleanup != nil {
+				cleanup()
+			}
+			closeFunc(err)
+			return
+		}
+		if cleanup != nil {
+			cleanup()
+		}
+	}
+}
+
+// entryContent returns the reader out.writeEntry should copy header's
+// content from: the prefetched LFS object if header is a pointer entry
+// (rewriting header.Size in place), or inTarball itself otherwise. The
+// returned cleanup, if non-nil, must be called once the entry has been
+// fully written. holder.done may be waited on by more than one entry
+// when an OID is checked into the repo at multiple paths; since it's
+// closed (rather than sent on) exactly once, every waiter observes the
+// same result without blocking past the first.
+func entryContent(header *tar.Header, inTarball *tar.Reader, results map[string]*lfsResultHolder) (io.Reader, func(), error) {
+	if header.Typeflag != tar.TypeReg || header.Size > lfsPointerMaxSize {
+		return inTarball, nil, nil
+	}
+	buf, err := ioutil.ReadAll(inTarball)
+	if err != nil {
+		return nil, nil, err
+	}
+	oid, _, ok := parseLFSPointer(buf)
+	if !ok {
+		return bytes.NewReader(buf), nil, nil
+	}
+	holder, found := results[oid]
+	if !found {
+		return nil, nil, fmt.Errorf("lfs object not resolved: %v (%v)", oid, header.Name)
+	}
+	<-holder.done
+	res := holder.result
+	if res.err != nil {
+		return nil, nil, fmt.Errorf("error fetching lfs object %v: %v", oid, res.err)
+	}
+	f, err := os.Open(res.path)
+	if err != nil {
+		return nil, nil, err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, nil, err
+	}
+	header.Size = info.Size()
+	return f, func() {
+		f.Close()
+		if atomic.AddInt32(&holder.refsRemaining, -1) > 0 {
+			return
+		}
+		os.Remove(res.path)
+	}, nil
+}
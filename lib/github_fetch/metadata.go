@@ -0,0 +1,186 @@
+package githubfetch
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/google/go-github/github"
+	"gopkg.in/DataDog/dd-trace-go.v1/ddtrace/tracer"
+)
+
+// MetadataEntry describes one file's placement within the archive
+// produced alongside it, so a caller holding a cached copy of the
+// archive can seek directly to an entry instead of re-scanning the
+// whole stream.
+type MetadataEntry struct {
+	Path            string
+	Size            int64
+	Mode            int64
+	Offset          int64 // offset of the entry's content within the tar stream
+	TarHeaderOffset int64 // offset of the entry's tar header within the tar stream
+}
+
+// Metadata is the sidecar index for an archive fetched via Get2.
+type Metadata struct {
+	Entries []MetadataEntry
+}
+
+// Get2 behaves like Get, but additionally returns a channel that
+// receives a single Metadata value built in the same streaming pass used
+// to strip the prefix and apply .dockerignore filtering. The Metadata is
+// only sent once the returned io.Reader has been fully drained (EOF or
+// error); callers must finish reading the archive before receiving from
+// the channel.
+//
+// Metadata offsets describe positions in the uncompressed tar stream, so
+// they're only meaningful for FormatTar, the one format Get2 produces
+// without a compression layer between the tar bytes and what the caller
+// receives. Get2 returns an error for FormatTarGz and FormatZip, rather
+// than silently handing back offsets that don't correspond to anything
+// the caller can seek into.
+func (gf *GitHubFetcher) Get2(parentSpan tracer.Span, owner string, repo string, ref string, opts GetOptions) (tarball io.Reader, metadata <-chan Metadata, err error) {
+	span := tracer.StartSpan("github_fetcher.get2", tracer.ChildOf(parentSpan.Context()))
+	defer func() {
+		span.Finish(tracer.WithError(err))
+	}()
+
+	if opts.Format != FormatTar {
+		return nil, nil, fmt.Errorf("metadata sidecar is only supported for FormatTar, got %v", opts.Format)
+	}
+
+	opt := &github.RepositoryContentGetOptions{Ref: ref}
+	ctx, cf := context.WithTimeout(context.Background(), githubDownloadTimeoutSecs*time.Second)
+	defer cf()
+
+	excludes, err := gf.parseDockerIgnoreIfExists(ctx, owner, repo, opt)
+	if err != nil {
+		return nil, nil, fmt.Errorf("error parsing %v file: %v", dockerIgnorePath, err)
+	}
+	archiveURL, resp, err := gf.c.Repositories.GetArchiveLink(ctx, owner, repo, github.Tarball, opt)
+	if err != nil {
+		return nil, nil, fmt.Errorf("error getting archive link: %v", err)
+	}
+	if resp.StatusCode > 399 {
+		return nil, nil, fmt.Errorf("error status when getting archive link: %v", resp.Status)
+	}
+	if archiveURL == nil {
+		return nil, nil, fmt.Errorf("url is nil")
+	}
+
+	body, err := gf.fetchArchiveBody(archiveURL)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return newTarPrefixStripperWithMetadata(body, excludes, opts.Format)
+}
+
+// countingWriter tracks the number of bytes written through it so
+// metadata entries can record their offsets within the underlying tar
+// stream.
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// newTarPrefixStripperWithMetadata runs the same prefix-stripping/
+// dockerignore pipeline as newTarPrefixStripper, additionally recording a
+// MetadataEntry for each emitted file. format must be FormatTar: that's
+// the only format whose output bytes are the tar stream the offsets are
+// computed against (callers enforce this before calling in).
+func newTarPrefixStripperWithMetadata(tarball io.ReadCloser, excludes []string, format ArchiveFormat) (io.Reader, <-chan Metadata, error) {
+	reader, writer := io.Pipe()
+	t := &tarPrefixStripper{
+		tarball:    tarball,
+		pipeReader: reader,
+		pipeWriter: writer,
+		excludes:   excludes,
+		format:     format,
+	}
+	metaCh := make(chan Metadata, 1)
+
+	go t.startStrippingPipeWithMetadata(metaCh)
+	t.strippingStarted = true
+	return t.pipeReader, metaCh, nil
+}
+
+func (t *tarPrefixStripper) startStrippingPipeWithMetadata(metaCh chan<- Metadata) {
+	var meta Metadata
+	defer func() {
+		metaCh <- meta
+		close(metaCh)
+	}()
+
+	gzr, err := gzip.NewReader(t.tarball)
+	if err != nil {
+		t.pipeWriter.CloseWithError(err)
+		return
+	}
+
+	inTarball := tar.NewReader(gzr)
+
+	cw := &countingWriter{w: t.pipeWriter}
+	tw := tar.NewWriter(cw)
+
+	closeFunc := func(e error) {
+		tw.Close()
+		t.pipeWriter.CloseWithError(e)
+		t.tarball.Close()
+	}
+
+	for {
+		header, err := inTarball.Next()
+		if err == io.EOF {
+			closeFunc(nil)
+			return
+		}
+		if err != nil {
+			closeFunc(err)
+			return
+		}
+
+		skip, err := t.processHeader(header)
+		if err != nil {
+			closeFunc(err)
+			return
+		}
+		if skip {
+			continue
+		}
+
+		tarHeaderOffset := cw.n
+		if err := tw.WriteHeader(header); err != nil {
+			closeFunc(err)
+			return
+		}
+		dataOffset := cw.n
+		if _, err := io.Copy(tw, inTarball); err != nil {
+			closeFunc(err)
+			return
+		}
+		if err := tw.Flush(); err != nil {
+			closeFunc(err)
+			return
+		}
+
+		if header.Typeflag == tar.TypeReg {
+			meta.Entries = append(meta.Entries, MetadataEntry{
+				Path:            header.Name,
+				Size:            header.Size,
+				Mode:            header.Mode,
+				Offset:          dataOffset,
+				TarHeaderOffset: tarHeaderOffset,
+			})
+		}
+	}
+}
@@ -0,0 +1,115 @@
+package gitlabfetch
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/docker/docker/builder/dockerignore"
+
+	githubfetch "github.com/vaguiner7/furan/lib/github_fetch"
+
+	gitlab "github.com/xanzy/go-gitlab"
+	"gopkg.in/DataDog/dd-trace-go.v1/ddtrace/tracer"
+)
+
+const (
+	gitlabDownloadTimeoutSecs = 300
+	dockerIgnorePath          = ".dockerignore"
+)
+
+// GitLabFetcher represents a gitlab data fetcher. It implements
+// githubfetch.CodeFetcher so Furan can build from either provider through
+// the same interface.
+type GitLabFetcher struct {
+	c *gitlab.Client
+}
+
+// NewGitLabFetcher returns a new gitlab fetcher. baseURL is the GitLab
+// instance API URL (e.g. https://gitlab.com/api/v4 or a self-hosted
+// equivalent); an empty baseURL defaults to gitlab.com.
+func NewGitLabFetcher(baseURL, token string) (*GitLabFetcher, error) {
+	opts := []gitlab.ClientOptionFunc{}
+	if baseURL != "" {
+		opts = append(opts, gitlab.WithBaseURL(baseURL))
+	}
+	c, err := gitlab.NewClient(token, opts...)
+	if err != nil {
+		return nil, fmt.Errorf("error creating gitlab client: %v", err)
+	}
+	return &GitLabFetcher{c: c}, nil
+}
+
+// projectPath builds the "owner/repo" project path GitLab expects as the
+// PID in its API calls.
+func projectPath(owner, repo string) string {
+	return owner + "/" + repo
+}
+
+// GetCommitSHA returns the commit SHA for a reference
+func (gf *GitLabFetcher) GetCommitSHA(parentSpan tracer.Span, owner string, repo string, ref string) (csha string, err error) {
+	span := tracer.StartSpan("gitlab_fetcher.get_commit_sha", tracer.ChildOf(parentSpan.Context()))
+	defer func() {
+		span.Finish(tracer.WithError(err))
+	}()
+	commit, _, err := gf.c.Commits.GetCommit(projectPath(owner, repo), ref, gitlab.WithContext(context.Background()))
+	if err != nil {
+		return "", fmt.Errorf("error getting commit: %v", err)
+	}
+	return commit.ID, nil
+}
+
+// Get fetches contents of a GitLab repo archive and returns the processed
+// contents as an in-memory io.Reader, in the archive format requested by
+// opts.
+func (gf *GitLabFetcher) Get(parentSpan tracer.Span, owner string, repo string, ref string, opts githubfetch.GetOptions) (tarball io.Reader, err error) {
+	span := tracer.StartSpan("gitlab_fetcher.get", tracer.ChildOf(parentSpan.Context()))
+	defer func() {
+		span.Finish(tracer.WithError(err))
+	}()
+	pid := projectPath(owner, repo)
+
+	excludes, err := gf.parseDockerIgnoreIfExists(pid, ref)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing %v file: %v", dockerIgnorePath, err)
+	}
+
+	return gf.getArchive(pid, ref, excludes, opts.Format)
+}
+
+// parseDockerIgnoreIfExists will parse the docker ignore file if it exists in order to determine which patterns should be excluded.
+// The excluded patterns are intended to be used with a pattern matcher.
+func (gf *GitLabFetcher) parseDockerIgnoreIfExists(pid, ref string) ([]string, error) {
+	f, _, err := gf.c.RepositoryFiles.GetRawFile(pid, dockerIgnorePath, &gitlab.GetRawFileOptions{Ref: gitlab.String(ref)})
+	if err != nil {
+		if resp, ok := err.(*gitlab.ErrorResponse); ok && resp.Response != nil && resp.Response.StatusCode == http.StatusNotFound {
+			// Not all repos will have dockerignore, just move along
+			return []string{}, nil
+		}
+		return nil, fmt.Errorf("error getting .dockerignore: %v", err)
+	}
+	excludes, err := dockerignore.ReadAll(strings.NewReader(string(f)))
+	if err != nil {
+		return nil, fmt.Errorf("error parsing %v, %v", dockerIgnorePath, err)
+	}
+	return excludes, nil
+}
+
+// getArchive streams the project's repository archive.tar.gz and feeds it
+// through the shared prefix-stripping/dockerignore pipeline.
+func (gf *GitLabFetcher) getArchive(pid, ref string, excludes []string, format githubfetch.ArchiveFormat) (io.Reader, error) {
+	ctx, cf := context.WithTimeout(context.Background(), gitlabDownloadTimeoutSecs*time.Second)
+	pr, pw := io.Pipe()
+	go func() {
+		defer cf()
+		_, err := gf.c.Repositories.StreamArchive(pid, pw, &gitlab.ArchiveOptions{
+			Format: gitlab.String("tar.gz"),
+			SHA:    gitlab.String(ref),
+		}, gitlab.WithContext(ctx))
+		pw.CloseWithError(err)
+	}()
+	return githubfetch.NewTarPrefixStripper(pr, excludes, format), nil
+}
@@ -0,0 +1,187 @@
+package gitlabfetch
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	githubfetch "github.com/vaguiner7/furan/lib/github_fetch"
+	"gopkg.in/DataDog/dd-trace-go.v1/ddtrace/tracer"
+)
+
+// testSpan returns a usable root span for tests to pass as the
+// parentSpan argument: GetCommitSHA/Get start a child span off of it, so
+// a nil tracer.Span would panic on parentSpan.Context(). The tracer is
+// never started in these tests, so this span is a no-op that's never
+// actually reported anywhere.
+func testSpan() tracer.Span {
+	return tracer.StartSpan("test")
+}
+
+// buildTarGz produces a gzip-compressed tar archive with a single
+// top-level directory entry followed by the given files, matching the
+// shape GitLab's repository archive endpoint returns (and that
+// newTarPrefixStripper, via githubfetch.NewTarPrefixStripper, expects to
+// strip the prefix from).
+func buildTarGz(t *testing.T, topDir string, files map[string]string) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	gzw := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gzw)
+
+	if err := tw.WriteHeader(&tar.Header{Name: topDir + "/", Typeflag: tar.TypeDir, Mode: 0755}); err != nil {
+		t.Fatalf("WriteHeader(topdir): %v", err)
+	}
+	for name, content := range files {
+		h := &tar.Header{Name: topDir + "/" + name, Mode: 0644, Size: int64(len(content))}
+		if err := tw.WriteHeader(h); err != nil {
+			t.Fatalf("WriteHeader(%v): %v", name, err)
+		}
+		if _, err := tw.Write([]byte(content)); err != nil {
+			t.Fatalf("Write(%v): %v", name, err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatalf("tar Close: %v", err)
+	}
+	if err := gzw.Close(); err != nil {
+		t.Fatalf("gzip Close: %v", err)
+	}
+	return buf.Bytes()
+}
+
+// newTestFetcher returns a GitLabFetcher pointed at a test server serving
+// mux, so GetCommitSHA/Get/parseDockerIgnoreIfExists can be exercised
+// against canned responses instead of the real GitLab API.
+func newTestFetcher(t *testing.T, mux *http.ServeMux) *GitLabFetcher {
+	t.Helper()
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+
+	gf, err := NewGitLabFetcher(srv.URL, "test-token")
+	if err != nil {
+		t.Fatalf("NewGitLabFetcher: %v", err)
+	}
+	return gf
+}
+
+func TestProjectPath(t *testing.T) {
+	if got, want := projectPath("owner", "repo"), "owner/repo"; got != want {
+		t.Errorf("projectPath = %q, want %q", got, want)
+	}
+}
+
+func TestGetCommitSHA(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/v4/projects/owner/repo/repository/commits/main", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"id": "deadbeef"}`)
+	})
+	gf := newTestFetcher(t, mux)
+
+	sha, err := gf.GetCommitSHA(testSpan(), "owner", "repo", "main")
+	if err != nil {
+		t.Fatalf("GetCommitSHA: %v", err)
+	}
+	if sha != "deadbeef" {
+		t.Errorf("GetCommitSHA = %q, want %q", sha, "deadbeef")
+	}
+}
+
+func TestGetCommitSHANotFound(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/v4/projects/owner/repo/repository/commits/missing", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+		fmt.Fprint(w, `{"message": "404 Commit Not Found"}`)
+	})
+	gf := newTestFetcher(t, mux)
+
+	if _, err := gf.GetCommitSHA(testSpan(), "owner", "repo", "missing"); err == nil {
+		t.Fatal("GetCommitSHA: err = nil, want error for a missing ref")
+	}
+}
+
+func TestParseDockerIgnoreIfExists(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/v4/projects/owner/repo/repository/files/.dockerignore/raw", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "vendor/\n*.log\n")
+	})
+	gf := newTestFetcher(t, mux)
+
+	excludes, err := gf.parseDockerIgnoreIfExists(projectPath("owner", "repo"), "main")
+	if err != nil {
+		t.Fatalf("parseDockerIgnoreIfExists: %v", err)
+	}
+	want := []string{"vendor/", "*.log"}
+	if len(excludes) != len(want) {
+		t.Fatalf("excludes = %v, want %v", excludes, want)
+	}
+	for i := range want {
+		if excludes[i] != want[i] {
+			t.Errorf("excludes[%v] = %q, want %q", i, excludes[i], want[i])
+		}
+	}
+}
+
+func TestParseDockerIgnoreIfExistsMissing(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/v4/projects/owner/repo/repository/files/.dockerignore/raw", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+		fmt.Fprint(w, `{"message": "404 File Not Found"}`)
+	})
+	gf := newTestFetcher(t, mux)
+
+	excludes, err := gf.parseDockerIgnoreIfExists(projectPath("owner", "repo"), "main")
+	if err != nil {
+		t.Fatalf("parseDockerIgnoreIfExists: %v", err)
+	}
+	if len(excludes) != 0 {
+		t.Errorf("excludes = %v, want none when .dockerignore is missing", excludes)
+	}
+}
+
+func TestGet(t *testing.T) {
+	archiveBytes := buildTarGz(t, "repo-main-deadbeef", map[string]string{"README.md": "hello world"})
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/v4/projects/owner/repo/repository/files/.dockerignore/raw", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+		fmt.Fprint(w, `{"message": "404 File Not Found"}`)
+	})
+	mux.HandleFunc("/api/v4/projects/owner/repo/repository/archive.tar.gz", func(w http.ResponseWriter, r *http.Request) {
+		if got, want := r.URL.Query().Get("sha"), "main"; got != want {
+			t.Errorf("archive request sha = %q, want %q", got, want)
+		}
+		w.Write(archiveBytes)
+	})
+	gf := newTestFetcher(t, mux)
+
+	r, err := gf.Get(testSpan(), "owner", "repo", "main", githubfetch.GetOptions{Format: githubfetch.FormatTar})
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	got, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatalf("ReadAll: %v", err)
+	}
+
+	tr := tar.NewReader(bytes.NewReader(got))
+	h, err := tr.Next()
+	if err != nil {
+		t.Fatalf("tar Next: %v", err)
+	}
+	if h.Name != "README.md" {
+		t.Errorf("entry name = %q, want %q (top-level dir should be stripped)", h.Name, "README.md")
+	}
+	content, err := ioutil.ReadAll(tr)
+	if err != nil {
+		t.Fatalf("read entry: %v", err)
+	}
+	if string(content) != "hello world" {
+		t.Errorf("entry content = %q, want %q", content, "hello world")
+	}
+}